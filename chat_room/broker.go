@@ -0,0 +1,63 @@
+package chat_room
+
+import (
+	"context"
+
+	chat_type "web_server/type"
+)
+
+// Broker fans a room's messages out across process boundaries so multiple
+// server instances can serve the same room. Room.BroadCast publishes every
+// message through the broker instead of writing straight to its local
+// broadcast channel; a single relay goroutine per room subscribes back and
+// feeds the local channel, so in-process and cross-process delivery share
+// one code path.
+type Broker interface {
+	// Publish sends m to every subscriber of topic, including subscribers
+	// in this same process.
+	Publish(ctx context.Context, topic string, m chat_type.Message) error
+
+	// Subscribe returns a channel of messages published to topic from any
+	// process. The channel is closed once ctx is done.
+	Subscribe(ctx context.Context, topic string) (<-chan chat_type.Message, error)
+}
+
+// localBroker is the default Broker: it never leaves the process. It just
+// loops published messages back to its own subscriber, which reproduces the
+// single-process behavior this package had before brokers existed.
+type localBroker struct {
+	messages chan chat_type.Message
+}
+
+// NewLocalBroker returns a Broker that keeps all fan-out in-process.
+func NewLocalBroker() Broker {
+	return &localBroker{messages: make(chan chat_type.Message, sendBufferSize)}
+}
+
+func (b *localBroker) Publish(ctx context.Context, _ string, m chat_type.Message) error {
+	select {
+	case b.messages <- m:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func (b *localBroker) Subscribe(ctx context.Context, _ string) (<-chan chat_type.Message, error) {
+	out := make(chan chat_type.Message)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m := <-b.messages:
+				select {
+				case out <- m:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,87 @@
+package chat_room
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	chat_type "web_server/type"
+)
+
+// RedisBroker fans room messages out across processes using Redis Pub/Sub,
+// so a room can be served by more than one node at once.
+type RedisBroker struct {
+	client *redis.Client
+}
+
+// NewRedisBroker returns a Broker backed by client.
+func NewRedisBroker(client *redis.Client) *RedisBroker {
+	return &RedisBroker{client: client}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, topic string, m chat_type.Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, topic, data).Err()
+}
+
+// TryBecomePrimary implements PrimaryElector using a Redis key per topic as
+// a lease: "<topic>:primary" holds the node ID currently allowed to persist
+// that room's history, with a TTL so a crashed primary's claim expires
+// instead of wedging the room forever.
+func (b *RedisBroker) TryBecomePrimary(ctx context.Context, topic string, nodeID string, ttl time.Duration) (bool, error) {
+	key := topic + ":primary"
+	acquired, err := b.client.SetNX(ctx, key, nodeID, ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	if acquired {
+		return true, nil
+	}
+	holder, err := b.client.Get(ctx, key).Result()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	if holder != nodeID {
+		return false, nil
+	}
+	if err := b.client.Expire(ctx, key, ttl).Err(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, topic string) (<-chan chat_type.Message, error) {
+	sub := b.client.Subscribe(ctx, topic)
+	out := make(chan chat_type.Message)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		redisMessages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-redisMessages:
+				if !ok {
+					return
+				}
+				var m chat_type.Message
+				if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+					slog.Error("redis broker: unmarshal message error", "error", err)
+					continue
+				}
+				select {
+				case out <- m:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,219 @@
+package chat_room
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"log/slog"
+	"sync/atomic"
+	"time"
+	chat_type "web_server/type"
+)
+
+const (
+	// sendBufferSize bounds how many outbound messages a client can have
+	// queued before it is treated as a slow consumer and dropped.
+	sendBufferSize = 256
+
+	// writeWait is the time allowed to write a message to the peer.
+	writeWait = 10 * time.Second
+
+	// pongWait is how long we wait for a pong before considering the
+	// connection dead.
+	pongWait = 60 * time.Second
+
+	// pingPeriod is how often pings are sent; must be less than pongWait.
+	pingPeriod = (pongWait * 9) / 10
+)
+
+// Client represents a single websocket connection bound to a Room.
+type Client struct {
+	ctx  context.Context
+	stop context.CancelFunc
+
+	*chat_type.User
+
+	conn *websocket.Conn
+
+	// send is the outbound message queue drained by writePump.
+	send chan []byte
+
+	// closeReq carries a close frame payload from a goroutine other than
+	// writePump (readPump's own profanity-kick, or serve()'s admin kick) to
+	// writePump, the only goroutine allowed to call conn.WriteMessage.
+	// Buffered by one: closeReq is sent at most once per client, right
+	// before the sender stops touching the connection.
+	closeReq chan []byte
+
+	// onMessage is invoked for every inbound message read from the socket.
+	onMessage func(u *chat_type.User, m chat_type.Message) error
+
+	// onClientLeave is invoked once readPump exits, win or lose.
+	onClientLeave func(c *Client)
+
+	// violations counts messages that tripped the moderation filter. Only
+	// readPump touches this, so it needs no synchronization.
+	violations int
+
+	// mutedUntil is the unix-nano time at which a muted client regains send
+	// access, or 0 if not muted. It is set by serve() (via applyAdminCommand,
+	// on a /mute or /unmute command) and read by this client's own readPump,
+	// two different goroutines, so it's stored atomically rather than as a
+	// plain time.Time.
+	mutedUntil atomic.Int64
+
+	// lastTyping is when this client's last typing indicator was allowed
+	// through, used to throttle repeated frames.
+	lastTyping time.Time
+}
+
+// Send marshals m and enqueues it for delivery to the client, blocking if
+// its send buffer is currently full.
+func (c *Client) Send(m chat_type.Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	c.send <- data
+	return nil
+}
+
+// TrySend attempts a non-blocking enqueue of m. It reports false instead of
+// blocking when the client's send buffer is full, so a slow consumer never
+// stalls the caller (typically Room.serve()'s broadcast loop).
+func (c *Client) TrySend(m chat_type.Message) bool {
+	data, err := json.Marshal(m)
+	if err != nil {
+		slog.Error("marshal message error", "error", err)
+		return true
+	}
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// Serve starts the read/write pumps for the client's websocket connection.
+func (c *Client) Serve() {
+	go c.writePump()
+	go c.readPump()
+}
+
+// Stop tears down the client's context, terminating its pumps.
+func (c *Client) Stop() {
+	c.stop()
+}
+
+// isMuted reports whether the client is currently serving a mute penalty.
+func (c *Client) isMuted() bool {
+	until := c.mutedUntil.Load()
+	return until != 0 && time.Now().UnixNano() < until
+}
+
+// mute silences the client until t.
+func (c *Client) mute(until time.Time) {
+	c.mutedUntil.Store(until.UnixNano())
+}
+
+// unmute clears any active mute penalty.
+func (c *Client) unmute() {
+	c.mutedUntil.Store(0)
+}
+
+// requestClose asks writePump to send data as a close frame and stop. It
+// never blocks: closeReq is only ever sent once per client, so the buffer
+// of one is always free, and if writePump has already exited there is
+// nothing useful left to do anyway.
+func (c *Client) requestClose(data []byte) {
+	select {
+	case c.closeReq <- data:
+	default:
+	}
+}
+
+// allowTyping reports whether enough time has passed since this client's
+// last typing broadcast, recording the time only when the frame is allowed.
+func (c *Client) allowTyping() bool {
+	now := time.Now()
+	if now.Sub(c.lastTyping) < typingThrottle {
+		return false
+	}
+	c.lastTyping = now
+	return true
+}
+
+func (c *Client) readPump() {
+	defer c.onClientLeave(c)
+	defer c.conn.Close()
+	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			slog.Info("read message error", "id", c.UserID, "error", err)
+			return
+		}
+		var message chat_type.Message
+		if err := json.Unmarshal(data, &message); err != nil {
+			slog.Error("unmarshal message error", "error", err)
+			continue
+		}
+		message.UserID = c.UserID
+		message.UserName = c.UserName
+
+		if c.isMuted() {
+			// Silently drop anything sent while muted.
+			continue
+		}
+
+		if containsProfanity(message.Content) {
+			c.violations++
+			_ = c.Send(chat_type.Message{Type: string(OpWarning), RoomName: message.RoomName, Content: "your message was blocked: please watch your language"})
+			if c.violations >= maxViolations {
+				slog.Warn("kicking client for repeated violations", "id", c.UserID, "userName", c.UserName)
+				c.requestClose(websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "kicked"))
+				return
+			}
+			continue
+		}
+
+		if err := c.onMessage(c.User, message); err != nil {
+			slog.Error("handle message error", "error", err)
+		}
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	defer c.conn.Close()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case data := <-c.closeReq:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			_ = c.conn.WriteMessage(websocket.CloseMessage, data)
+			return
+		case message, ok := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package chat_room
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// electionInterval is how often a node renews or attempts to claim primary
+// status for a room.
+const electionInterval = 5 * time.Second
+
+// electionTTL is how long a primary claim lasts before another node may
+// take it over, if the current holder stops renewing it.
+const electionTTL = 15 * time.Second
+
+// PrimaryElector is implemented by brokers that can coordinate which node
+// persists a shared room's history, so only one node ever writes it.
+// localBroker doesn't implement this: a single, unshared process is always
+// its own primary.
+type PrimaryElector interface {
+	// TryBecomePrimary attempts to claim or renew primary status for topic
+	// on behalf of nodeID. It reports whether nodeID holds primary status
+	// once the call returns.
+	TryBecomePrimary(ctx context.Context, topic string, nodeID string, ttl time.Duration) (bool, error)
+}
+
+// electPrimary keeps h.isPrimary in sync with the broker's view of who
+// holds primary status for this room, when the broker supports election.
+// A broker that doesn't (the default localBroker) leaves isPrimary at its
+// initial true, matching single-node behavior.
+func (h *Room) electPrimary() {
+	elector, ok := h.broker.(PrimaryElector)
+	if !ok {
+		return
+	}
+	ticker := time.NewTicker(electionInterval)
+	defer ticker.Stop()
+	for {
+		primary, err := elector.TryBecomePrimary(h.ctx, h.topic(), h.nodeID, electionTTL)
+		if err != nil {
+			slog.Error("primary election error", "error", err, "roomName", h.RoomName)
+		} else {
+			h.isPrimary.Store(primary)
+		}
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package chat_room
+
+import (
+	"log/slog"
+	"time"
+)
+
+// lifecycleMode selects what a Room does once it has no clients left.
+type lifecycleMode int
+
+const (
+	lifecyclePersistent lifecycleMode = iota
+	lifecycleRemoveWhenEmpty
+	lifecycleRemoveAfterIdle
+)
+
+// RoomLifecycle governs whether an empty room is kept around, torn down
+// immediately, or torn down after sitting idle for a while.
+type RoomLifecycle struct {
+	mode        lifecycleMode
+	idleTimeout time.Duration
+}
+
+// Persistent keeps the room alive indefinitely, even with no clients. This
+// is the default, matching this package's behavior before lifecycle
+// policies existed.
+func Persistent() RoomLifecycle {
+	return RoomLifecycle{mode: lifecyclePersistent}
+}
+
+// RemoveWhenEmpty tears the room down as soon as its last client leaves.
+func RemoveWhenEmpty() RoomLifecycle {
+	return RoomLifecycle{mode: lifecycleRemoveWhenEmpty}
+}
+
+// RemoveAfterIdle tears the room down if it stays empty for d, cancelling
+// the countdown the moment a new client registers.
+func RemoveAfterIdle(d time.Duration) RoomLifecycle {
+	return RoomLifecycle{mode: lifecycleRemoveAfterIdle, idleTimeout: d}
+}
+
+// onEmpty applies h.Lifecycle now that the room has zero clients.
+func (h *Room) onEmpty() {
+	switch h.Lifecycle.mode {
+	case lifecycleRemoveWhenEmpty:
+		slog.Info("room is empty, removing", "roomName", h.RoomName)
+		RemoveChatRoom(h.RoomName)
+		h.Stop()
+	case lifecycleRemoveAfterIdle:
+		slog.Info("room is empty, starting idle timer", "roomName", h.RoomName, "timeout", h.Lifecycle.idleTimeout)
+		timer := time.NewTimer(h.Lifecycle.idleTimeout)
+		h.idleTimer = timer
+		h.emptyTimerC = timer.C
+	default: // lifecyclePersistent: nothing to do
+	}
+}
+
+// cancelIdleTimer stops any pending RemoveAfterIdle countdown, called once
+// the room gets a client back.
+func (h *Room) cancelIdleTimer() {
+	if h.idleTimer == nil {
+		return
+	}
+	h.idleTimer.Stop()
+	h.idleTimer = nil
+	h.emptyTimerC = nil
+}
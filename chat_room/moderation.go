@@ -0,0 +1,106 @@
+package chat_room
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	chat_type "web_server/type"
+)
+
+// maxViolations is the number of profanity-filter trips tolerated before a
+// client is force-kicked from the room.
+const maxViolations = 3
+
+// muteDuration is how long a /mute command silences a client for.
+const muteDuration = 10 * time.Minute
+
+// blocklist holds the patterns a message body is checked against before it
+// is allowed to reach the rest of the room.
+// Each pattern only anchors the leading edge (\b) and not the trailing one,
+// so inflected forms like "fucking", "shitty" and "bitches" are caught too,
+// not just the bare word set off by punctuation or whitespace.
+var blocklist = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\bfuck`),
+	regexp.MustCompile(`(?i)\bshit`),
+	regexp.MustCompile(`(?i)\bbitch`),
+}
+
+// containsProfanity reports whether body trips any entry in blocklist.
+func containsProfanity(body string) bool {
+	for _, re := range blocklist {
+		if re.MatchString(body) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetOwner designates the user allowed to issue /mute, /kick and /unmute
+// commands in this room.
+func (h *Room) SetOwner(userID string) {
+	h.OwnerUserID = userID
+}
+
+// adminCommand is a /mute, /kick or /unmute request handed from the issuing
+// client's readPump goroutine to serve(), the only goroutine allowed to
+// read h.clientsByUser or touch a target client's muted state.
+type adminCommand struct {
+	ownerID  string
+	cmd      string
+	targetID string
+}
+
+// handleAdminCommand runs in the issuing client's own readPump goroutine. It
+// only parses the command; the owner check, target lookup and mutation all
+// happen in applyAdminCommand, inside serve(). It returns true when m was an
+// admin command (whether accepted or rejected), in which case it must not be
+// broadcast further.
+func (h *Room) handleAdminCommand(u *chat_type.User, m chat_type.Message) bool {
+	if m.Type != string(OpSendText) || !strings.HasPrefix(m.Content, "/") {
+		return false
+	}
+	fields := strings.Fields(m.Content)
+	if len(fields) < 2 {
+		return false
+	}
+	cmd, targetID := fields[0], fields[1]
+	switch cmd {
+	case "/mute", "/kick", "/unmute":
+	default:
+		return false
+	}
+	h.adminCmd <- adminCommand{ownerID: u.UserID, cmd: cmd, targetID: targetID}
+	return true
+}
+
+// applyAdminCommand validates and executes cmd. Only called from serve().
+func (h *Room) applyAdminCommand(cmd adminCommand) {
+	if h.OwnerUserID == "" || cmd.ownerID != h.OwnerUserID {
+		slog.Warn("rejected admin command from non-owner", "userID", cmd.ownerID, "cmd", cmd.cmd)
+		return
+	}
+	target, ok := h.clientsByUser[cmd.targetID]
+	if !ok {
+		return
+	}
+	switch cmd.cmd {
+	case "/mute":
+		target.mute(time.Now().Add(muteDuration))
+		slog.Info("muted client", "userID", cmd.targetID, "roomName", h.RoomName)
+	case "/unmute":
+		target.unmute()
+		slog.Info("unmuted client", "userID", cmd.targetID, "roomName", h.RoomName)
+	case "/kick":
+		slog.Info("kicked client via admin command", "userID", cmd.targetID, "roomName", h.RoomName)
+		target.requestClose(websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "kicked"))
+		delete(h.clients, target)
+		delete(h.clientsByUser, cmd.targetID)
+		target.Stop()
+		if len(h.clients) == 0 {
+			h.onEmpty()
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package chat_room
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContainsProfanity(t *testing.T) {
+	cases := []struct {
+		body string
+		want bool
+	}{
+		{"hello there", false},
+		{"this is FUCKing great", true},
+		{"what the shit", true},
+		{"that was a shitty thing to do", true},
+		{"don't be a bitch", true},
+		{"stop being such bitches", true},
+		{"classic, nothing wrong here", false},
+	}
+	for _, c := range cases {
+		if got := containsProfanity(c.body); got != c.want {
+			t.Errorf("containsProfanity(%q) = %v, want %v", c.body, got, c.want)
+		}
+	}
+}
+
+func TestClientMuteStateMachine(t *testing.T) {
+	c := &Client{}
+
+	if c.isMuted() {
+		t.Fatal("new client should not be muted")
+	}
+
+	c.mute(time.Now().Add(time.Hour))
+	if !c.isMuted() {
+		t.Fatal("client should be muted after mute()")
+	}
+
+	c.unmute()
+	if c.isMuted() {
+		t.Fatal("client should not be muted after unmute()")
+	}
+
+	c.mute(time.Now().Add(-time.Second))
+	if c.isMuted() {
+		t.Fatal("client with a mute that already expired should not be muted")
+	}
+}
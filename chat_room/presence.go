@@ -0,0 +1,79 @@
+package chat_room
+
+import (
+	"time"
+
+	chat_type "web_server/type"
+)
+
+const (
+	OpPresenceJoin  Opcode = "join"
+	OpPresenceLeave Opcode = "leave"
+	OpTyping        Opcode = "typing"
+	OpStoppedTyping Opcode = "stopped_typing"
+	OpIdle          Opcode = "idle"
+	OpRoomUsers     Opcode = "roomUsers"
+)
+
+// typingThrottle bounds how often a single user's typing indicator is
+// rebroadcast to the room.
+const typingThrottle = 2 * time.Second
+
+func init() {
+	handlers[OpTyping] = handleTyping
+	handlers[OpStoppedTyping] = handlePresencePassthrough
+	handlers[OpIdle] = handlePresencePassthrough
+	handlers[OpRoomUsers] = handleRoomUsers
+}
+
+// handlePresencePassthrough broadcasts presence frames the client sends
+// unmodified; there is nothing to rate-limit or compute beyond what the
+// client already reported.
+func handlePresencePassthrough(h *Room, _ *Client, m chat_type.Message) *chat_type.Message {
+	h.BroadCast(m)
+	return nil
+}
+
+// handleTyping rate-limits a user's typing indicator to at most once every
+// typingThrottle before rebroadcasting it.
+func handleTyping(h *Room, c *Client, m chat_type.Message) *chat_type.Message {
+	if !c.allowTyping() {
+		return nil
+	}
+	h.BroadCast(m)
+	return nil
+}
+
+// handleRoomUsers runs in the requesting client's own readPump goroutine, so
+// it must not touch h.clients directly; it hands the request to serve(),
+// the only goroutine allowed to read that map, instead.
+func handleRoomUsers(h *Room, c *Client, _ chat_type.Message) *chat_type.Message {
+	h.roomUsersReq <- c
+	return nil
+}
+
+// ListUsers returns the users currently registered in the room.
+func (h *Room) ListUsers() []*chat_type.User {
+	users := make([]*chat_type.User, 0, len(h.clients))
+	for c := range h.clients {
+		users = append(users, c.User)
+	}
+	return users
+}
+
+// roomUsersMessage builds the roster response for the roomUsers opcode.
+func (h *Room) roomUsersMessage() chat_type.Message {
+	return chat_type.Message{Type: string(OpRoomUsers), RoomName: h.RoomName, Users: h.ListUsers()}
+}
+
+// sendRoomUsers delivers the current roster straight to c, so a newly
+// joined user can render it immediately instead of waiting on the next
+// join/leave presence event.
+func (h *Room) sendRoomUsers(c *Client) {
+	_ = c.Send(h.roomUsersMessage())
+}
+
+// presenceMessage builds a join/leave presence event for u in this room.
+func (h *Room) presenceMessage(op Opcode, u *chat_type.User) chat_type.Message {
+	return chat_type.Message{Type: string(op), RoomName: h.RoomName, UserID: u.UserID, UserName: u.UserName}
+}
@@ -0,0 +1,98 @@
+package chat_room
+
+import (
+	chat_type "web_server/type"
+)
+
+// Opcode identifies the kind of request or response carried by a Message.
+//
+// chat_type.Message.Type is a plain string (chat_type lives in the separate
+// web_server/type package, outside this package's control), so these are
+// named string constants rather than a true int enum. Dispatch through
+// Client.onMessage and request/response correlation via RequestID work the
+// same regardless.
+type Opcode string
+
+const (
+	// Request opcodes.
+	OpListRooms     Opcode = "roomList"
+	OpSendText      Opcode = "text"
+	OpSendImage     Opcode = "image"
+	OpSendFile      Opcode = "file"
+	OpDirectMessage Opcode = "dm"
+	OpPing          Opcode = "ping"
+
+	// Response opcodes.
+	OpRoomList    Opcode = "roomList"
+	OpUserCount   Opcode = "userCount"
+	OpHistoryOver Opcode = "over"
+	OpWarning     Opcode = "warning"
+	OpPong        Opcode = "pong"
+	OpError       Opcode = "error"
+)
+
+// JoinRoom, LeaveRoom and ChangeNick, from the opcode set this protocol was
+// originally specified with, are deliberately not in this table.
+//
+// JoinRoom/LeaveRoom don't fit how a Client relates to a Room in this
+// package: a connection is bound to exactly one Room for its whole
+// lifetime (Room.UserJoin), and leaving is just closing that connection —
+// there's no in-room RPC for either to dispatch to.
+//
+// ChangeNick would need a client's UserName to become mutable after
+// connect. Every other per-client field that changes after connect in this
+// package has its own synchronization story: mutedUntil is atomic because
+// more than one goroutine touches it, and violations and lastTyping are
+// touched only by readPump. UserName has neither story, and several
+// goroutines already read it assuming it's fixed
+// for the life of the connection (presence/roster messages, register and
+// unregister logging). Making it mutable needs the same care those fields
+// got, not just a handler, so it's left out rather than shipped racy.
+
+// handler processes one inbound message for a client and optionally returns
+// a response to send back to just the sender.
+type handler func(h *Room, c *Client, m chat_type.Message) *chat_type.Message
+
+// handlers dispatches inbound opcodes to their processing logic. This table
+// replaces growing the broadcast-everything switch in Client.onMessage by
+// hand for every new feature.
+var handlers = map[Opcode]handler{
+	OpSendText:      handleBroadcastable,
+	OpSendImage:     handleBroadcastable,
+	OpSendFile:      handleBroadcastable,
+	OpDirectMessage: handleBroadcastable,
+	OpListRooms:     handleListRooms,
+	OpPing:          handlePing,
+}
+
+// handleBroadcastable covers every opcode whose job is simply to enter the
+// room's normal broadcast pipeline (Room.serve's switch on message.Type
+// still decides history/DM handling from there).
+func handleBroadcastable(h *Room, _ *Client, m chat_type.Message) *chat_type.Message {
+	h.BroadCast(m)
+	return nil
+}
+
+func handleListRooms(h *Room, c *Client, _ chat_type.Message) *chat_type.Message {
+	h.sendRoomList(c)
+	return nil
+}
+
+func handlePing(h *Room, _ *Client, m chat_type.Message) *chat_type.Message {
+	return &chat_type.Message{Type: string(OpPong), RequestID: m.RequestID, RoomName: h.RoomName}
+}
+
+// dispatch routes m to its opcode handler, sending any response back to c.
+// It reports whether m was recognized so callers can fall back to a plain
+// broadcast for opcodes not in the table. Admin commands are not handled
+// here; UserJoin's onMessage checks for them before calling dispatch.
+func (h *Room) dispatch(c *Client, m chat_type.Message) bool {
+	fn, ok := handlers[Opcode(m.Type)]
+	if !ok {
+		return false
+	}
+	if resp := fn(h, c, m); resp != nil {
+		_ = c.Send(*resp)
+	}
+	return true
+}
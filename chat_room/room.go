@@ -2,16 +2,25 @@ package chat_room
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 	chat_db "web_server/db"
 	chat_type "web_server/type"
 )
 
 const maxHistoryCount = 100
 
+// maxSeenMessageIDs bounds how many message IDs the broker relay remembers
+// to dedup echoes; older IDs are evicted once the cap is hit.
+const maxSeenMessageIDs = 1000
+
 type Room struct {
 	ctx  context.Context
 	stop context.CancelFunc
@@ -19,6 +28,10 @@ type Room struct {
 	// Registered clients.
 	clients map[*Client]bool
 
+	// clientsByUser indexes the same clients by UserID so direct messages
+	// can be delivered without scanning every client in the room.
+	clientsByUser map[string]*Client
+
 	// Inbound messages from the clients.
 	broadcast chan chat_type.Message
 
@@ -28,71 +41,217 @@ type Room struct {
 	// Unregister requests from clients.
 	unregister chan *Client
 
+	// roomUsersReq carries roster requests (the roomUsers opcode) into
+	// serve(), since h.clients may only be read from that goroutine.
+	roomUsersReq chan *Client
+
+	// adminCmd carries /mute, /kick and /unmute requests into serve(), since
+	// clientsByUser and a target client's muted state may only be touched
+	// from that goroutine.
+	adminCmd chan adminCommand
+
+	// dmMessages holds direct messages separately from the public history
+	// in ChatRoom.Messages so sendHistory can filter what each client sees.
+	dmMessages []chat_type.Message
+
+	// OwnerUserID is the only user allowed to issue /mute, /kick and
+	// /unmute admin commands. Empty means the room has no owner yet.
+	OwnerUserID string
+
+	// broker fans messages out across processes. It defaults to an
+	// in-process loopback broker so a single-node deployment behaves
+	// exactly as before; call SetBroker before Serve to share the room
+	// across nodes.
+	broker Broker
+
+	// nodeID identifies this process for message IDs and for deciding
+	// which node is the persistence primary.
+	nodeID string
+	msgSeq uint64
+
+	// isPrimary reports whether this node currently persists the room's
+	// history to chat_db. Defaults to true, so an unclustered process
+	// (whose broker is the default localBroker, which doesn't implement
+	// PrimaryElector) keeps writing history exactly as before. When the
+	// broker does implement PrimaryElector, electPrimary keeps this in
+	// sync with the broker's view of who holds the claim, so exactly one
+	// node writes at a time. Set from electPrimary's goroutine and read
+	// from serve()'s, so it's atomic rather than a plain bool.
+	isPrimary atomic.Bool
+
+	// seen/seenOrder dedup messages relayed back through the broker so a
+	// node never processes its own (or another node's) message twice.
+	// Only touched from the relay goroutine, so no locking is needed.
+	seen      map[string]struct{}
+	seenOrder []string
+
+	// Lifecycle decides what happens to the room once it has no clients
+	// left. Defaults to Persistent.
+	Lifecycle RoomLifecycle
+
+	// idleTimer/emptyTimerC back a RemoveAfterIdle countdown; emptyTimerC
+	// is nil (so its select case never fires) whenever no countdown is
+	// running.
+	idleTimer   *time.Timer
+	emptyTimerC <-chan time.Time
+
 	// Current room
 	*chat_type.ChatRoom
 }
 
 func newRoom(room *chat_type.ChatRoom) *Room {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Room{
-		ctx:        ctx,
-		stop:       cancel,
-		broadcast:  make(chan chat_type.Message),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
-		ChatRoom:   room,
+	h := &Room{
+		ctx:           ctx,
+		stop:          cancel,
+		broadcast:     make(chan chat_type.Message),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		roomUsersReq:  make(chan *Client),
+		adminCmd:      make(chan adminCommand),
+		clients:       make(map[*Client]bool),
+		clientsByUser: make(map[string]*Client),
+		broker:        NewLocalBroker(),
+		nodeID:        newNodeID(),
+		seen:          make(map[string]struct{}),
+		Lifecycle:     Persistent(),
+		ChatRoom:      room,
+	}
+	h.isPrimary.Store(true)
+	return h
+}
+
+// newNodeID returns a short random identifier for this process.
+func newNodeID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SetBroker swaps in a Broker (e.g. a RedisBroker) so the room can be
+// shared across nodes. Call it before Serve.
+func (h *Room) SetBroker(b Broker) {
+	h.broker = b
+}
+
+// topic is the broker topic this room's messages are published/subscribed
+// under.
+func (h *Room) topic() string {
+	return "chat_room:" + h.RoomName
+}
+
+// nextMessageID mints a process-unique message ID used for broker dedup.
+func (h *Room) nextMessageID() string {
+	seq := atomic.AddUint64(&h.msgSeq, 1)
+	return fmt.Sprintf("%s-%d", h.nodeID, seq)
+}
+
+// seenMessage reports whether id has already been relayed, recording it if
+// not.
+func (h *Room) seenMessage(id string) bool {
+	if _, ok := h.seen[id]; ok {
+		return true
 	}
+	h.seen[id] = struct{}{}
+	h.seenOrder = append(h.seenOrder, id)
+	if len(h.seenOrder) > maxSeenMessageIDs {
+		delete(h.seen, h.seenOrder[0])
+		h.seenOrder = h.seenOrder[1:]
+	}
+	return false
 }
 
-// BroadCast 全房间广播消息
+// BroadCast 全房间广播消息，通过 broker 发布，由 relayBroker 统一注入本地 broadcast
 func (h *Room) BroadCast(m chat_type.Message) {
-	h.broadcast <- m
+	if m.ID == "" {
+		m.ID = h.nextMessageID()
+	}
+	if err := h.broker.Publish(h.ctx, h.topic(), m); err != nil {
+		slog.Error("broker publish error", "error", err, "roomName", h.RoomName)
+	}
 }
 
 // UserJoin 将用户加入房间
 func (h *Room) UserJoin(conn *websocket.Conn, user *chat_type.User) {
 	ctx, cancel := context.WithCancel(h.ctx)
-	client := &Client{
+	var client *Client
+	client = &Client{
 		ctx:  ctx,
 		stop: cancel,
 		User: user,
 		conn: conn,
 		onMessage: func(u *chat_type.User, m chat_type.Message) error {
+			if h.handleAdminCommand(u, m) {
+				return nil
+			}
+			if h.dispatch(client, m) {
+				return nil
+			}
 			h.BroadCast(m)
 			return nil
 		},
-		send: make(chan []byte),
+		send:     make(chan []byte, sendBufferSize),
+		closeReq: make(chan []byte, 1),
 		onClientLeave: func(c *Client) {
 			slog.Info("user leave", "id", c.UserID, "userName", c.UserName, "roomName", h.RoomName)
 			h.unregister <- c
+			h.BroadCast(h.presenceMessage(OpPresenceLeave, c.User))
 			h.broadRoomUserCountMessage()
 		},
 	}
 	client.Serve()
 	slog.Info("new user join", "id", user.UserID, "userName", user.UserName, "roomName", h.RoomName)
 	h.register <- client
+	h.BroadCast(h.presenceMessage(OpPresenceJoin, user))
 	h.broadRoomUserCountMessage()
 }
 
+// rejectFull tells client the room is full and tears its connection down.
+// Only called from serve(), after it has already decided client must not be
+// admitted.
+func (h *Room) rejectFull(client *Client) {
+	_ = client.Send(chat_type.Message{Type: string(OpError), RoomName: h.RoomName, Content: "room is full"})
+	client.requestClose(websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "room full"))
+	client.Stop()
+}
+
 // sendHistory 发送历史消息
 func (h *Room) sendHistory(c *Client) {
-	var messages []chat_type.Message
+	for _, message := range h.publicHistory() {
+		_ = c.Send(message)
+	}
+	for _, message := range h.dmMessages {
+		// Only deliver a DM to the two parties that took part in it.
+		if message.UserID == c.UserID || message.RecipientID == c.UserID {
+			_ = c.Send(message)
+		}
+	}
+	_ = c.Send(chat_type.Message{Type: string(OpHistoryOver), RoomName: h.RoomName})
+}
+
+// publicHistory 返回房间公开消息的历史记录，最多保留 maxHistoryCount 条
+func (h *Room) publicHistory() []chat_type.Message {
 	if len(h.Messages) > maxHistoryCount {
 		// 保留最新100条
-		messages = h.Messages[len(h.Messages)-maxHistoryCount:]
-	} else {
-		messages = h.Messages
+		return h.Messages[len(h.Messages)-maxHistoryCount:]
 	}
+	return h.Messages
+}
 
-	for _, message := range messages {
-		_ = c.Send(message)
+// sendDirect 将私信只投递给发送者和接收者，而不是广播给房间内所有客户端
+func (h *Room) sendDirect(m chat_type.Message) {
+	if c, ok := h.clientsByUser[m.RecipientID]; ok {
+		_ = c.TrySend(m)
+	}
+	if m.UserID != m.RecipientID {
+		if c, ok := h.clientsByUser[m.UserID]; ok {
+			_ = c.TrySend(m)
+		}
 	}
-	_ = c.Send(chat_type.Message{Type: "over", RoomName: h.RoomName})
 }
 
 func (h *Room) sendRoomList(c *Client) {
-	_ = c.Send(chat_type.Message{Type: "roomList", ChatRoomList: ListChatRoom()})
+	_ = c.Send(chat_type.Message{Type: string(OpRoomList), ChatRoomList: ListChatRoom()})
 }
 
 func (h *Room) broadRoomUserCountMessage() {
@@ -117,7 +276,7 @@ func (h *Room) broadRoomUserCountMessage() {
 	if userCount <= 0 {
 		return
 	}
-	h.BroadCast(chat_type.Message{Type: "userCount", Data: jsonData})
+	h.BroadCast(chat_type.Message{Type: string(OpUserCount), Data: jsonData})
 }
 
 func (h *Room) serve() {
@@ -126,28 +285,65 @@ func (h *Room) serve() {
 		case <-h.ctx.Done():
 			close(h.register)
 			close(h.unregister)
+			close(h.roomUsersReq)
+			close(h.adminCmd)
 			close(h.broadcast)
 			return
+		case client := <-h.roomUsersReq:
+			h.sendRoomUsers(client)
+		case cmd := <-h.adminCmd:
+			h.applyAdminCommand(cmd)
 		case client := <-h.register:
+			// MaxUsers comes from chat_type.ChatRoom (loaded from config);
+			// -1 means unlimited. Checked here, inside serve()'s single
+			// goroutine, so two connections racing to join can't both be
+			// admitted past the cap.
+			if h.MaxUsers >= 0 && len(h.clients) >= h.MaxUsers {
+				slog.Warn("room is full, rejecting user", "roomName", h.RoomName, "userID", client.UserID, "maxUsers", h.MaxUsers)
+				h.rejectFull(client)
+				continue
+			}
 			h.clients[client] = true
+			h.clientsByUser[client.UserID] = client
+			h.cancelIdleTimer()
 			slog.Info("new user register", "id", client.UserID, "userName", client.UserName, "roomName", h.RoomName)
+			// Send the roster from inside serve(), the only goroutine
+			// allowed to touch h.clients, so a newly-joined user gets it
+			// immediately rather than waiting on the next presence event.
+			h.sendRoomUsers(client)
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
+				delete(h.clientsByUser, client.UserID)
 				client.Stop()
 			}
-			//if len(h.clients) == 0 {
-			//	slog.Warn("room is empty", "roomName", h.RoomName)
-			//	RemoveChatRoom(h.RoomName)
-			//}
+			if len(h.clients) == 0 {
+				h.onEmpty()
+			}
+		case <-h.emptyTimerC:
+			slog.Warn("room idle timeout reached, removing", "roomName", h.RoomName)
+			RemoveChatRoom(h.RoomName)
+			h.Stop()
 		case message := <-h.broadcast:
 			switch message.Type {
-			case "text", "image", "file":
+			case string(OpSendText), string(OpSendImage), string(OpSendFile):
 				h.Messages = append(h.Messages, message)
-				_ = chat_db.WriteChatInfoToLocalFile(h.ChatRoom)
+				if h.isPrimary.Load() {
+					_ = chat_db.WriteChatInfoToLocalFile(h.ChatRoom)
+				}
+			case string(OpDirectMessage):
+				h.dmMessages = append(h.dmMessages, message)
+				h.sendDirect(message)
+				continue
 			}
 			for client := range h.clients {
-				_ = client.Send(message)
+				if !client.TrySend(message) {
+					slog.Warn("dropping slow client", "id", client.UserID, "userName", client.UserName, "roomName", h.RoomName)
+					delete(h.clients, client)
+					delete(h.clientsByUser, client.UserID)
+					client.Stop()
+					_ = client.conn.Close()
+				}
 			}
 		}
 	}
@@ -156,10 +352,38 @@ func (h *Room) serve() {
 func (h *Room) Serve() {
 	h.init.Do(func() {
 		slog.Info("room serve", "roomName", h.RoomName)
+		go h.relayBroker()
+		go h.electPrimary()
 		go h.serve()
 	})
 }
 
+// relayBroker subscribes to the room's broker topic and injects each
+// distinct message into the local broadcast channel exactly once, so
+// locally- and remotely-originated messages go through the same delivery
+// path in serve().
+func (h *Room) relayBroker() {
+	sub, err := h.broker.Subscribe(h.ctx, h.topic())
+	if err != nil {
+		slog.Error("broker subscribe error", "error", err, "roomName", h.RoomName)
+		return
+	}
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case m, ok := <-sub:
+			if !ok {
+				return
+			}
+			if h.seenMessage(m.ID) {
+				continue
+			}
+			h.broadcast <- m
+		}
+	}
+}
+
 func (h *Room) UserCount() int {
 	return len(h.clients)
 }